@@ -0,0 +1,111 @@
+package mysql
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func testValues() map[string]interface{} {
+	return map[string]interface{}{
+		"foo": "bar",
+		"n":   42,
+		"ok":  true,
+	}
+}
+
+func TestJSONCodec(t *testing.T) {
+	Convey("Test JSON codec round-trip", t, func() {
+		data, err := JSONCodec.Marshal(testValues())
+		So(err, ShouldBeNil)
+
+		var got map[string]interface{}
+		err = JSONCodec.Unmarshal(data, &got)
+		So(err, ShouldBeNil)
+		So(got["foo"], ShouldEqual, "bar")
+		So(got["ok"], ShouldEqual, true)
+
+		Convey("empty values marshal to nil and unmarshal to an empty map", func() {
+			data, err := JSONCodec.Marshal(map[string]interface{}{})
+			So(err, ShouldBeNil)
+			So(data, ShouldBeNil)
+
+			var empty map[string]interface{}
+			err = JSONCodec.Unmarshal(data, &empty)
+			So(err, ShouldBeNil)
+			So(empty, ShouldNotBeNil)
+			So(len(empty), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestGobCodec(t *testing.T) {
+	Convey("Test gob codec round-trip", t, func() {
+		data, err := GobCodec.Marshal(testValues())
+		So(err, ShouldBeNil)
+
+		var got map[string]interface{}
+		err = GobCodec.Unmarshal(data, &got)
+		So(err, ShouldBeNil)
+		So(got["foo"], ShouldEqual, "bar")
+		So(got["n"], ShouldEqual, 42)
+	})
+}
+
+func TestMsgpackCodec(t *testing.T) {
+	Convey("Test msgpack codec round-trip", t, func() {
+		data, err := MsgpackCodec.Marshal(testValues())
+		So(err, ShouldBeNil)
+
+		var got map[string]interface{}
+		err = MsgpackCodec.Unmarshal(data, &got)
+		So(err, ShouldBeNil)
+		So(got["foo"], ShouldEqual, "bar")
+		So(got["ok"], ShouldEqual, true)
+	})
+}
+
+func TestEncryptedCodec(t *testing.T) {
+	key := []byte("0123456789abcdef")
+
+	Convey("Test encrypted codec round-trip", t, func() {
+		codec, err := NewEncryptedCodec(JSONCodec, key)
+		So(err, ShouldBeNil)
+
+		data, err := codec.Marshal(testValues())
+		So(err, ShouldBeNil)
+		So(data, ShouldNotBeNil)
+
+		var got map[string]interface{}
+		err = codec.Unmarshal(data, &got)
+		So(err, ShouldBeNil)
+		So(got["foo"], ShouldEqual, "bar")
+
+		Convey("plaintext never appears in the ciphertext", func() {
+			So(string(data), ShouldNotContainSubstring, "bar")
+		})
+
+		Convey("tampering with the ciphertext is detected", func() {
+			tampered := append([]byte(nil), data...)
+			tampered[len(tampered)-1] ^= 0xFF
+
+			var out map[string]interface{}
+			err := codec.Unmarshal(tampered, &out)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("wrong key fails to decrypt", func() {
+			other, err := NewEncryptedCodec(JSONCodec, []byte("fedcba9876543210"))
+			So(err, ShouldBeNil)
+
+			var out map[string]interface{}
+			err = other.Unmarshal(data, &out)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("An invalid key size is rejected", t, func() {
+		_, err := NewEncryptedCodec(JSONCodec, []byte("too-short"))
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,177 @@
+package mysql
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/gorp.v2"
+)
+
+// Dialect bundles a gorp.Dialect with the bits this package needs beyond
+// what gorp.Dialect already exposes: the driver name it expects to be
+// registered, and how to phrase an upsert and a bounded batch delete for
+// that database. It lets a single managerStore implementation serve
+// Postgres, SQLite, MySQL, or any other backend a caller supplies a
+// gorp.Dialect for (MSSQL, Oracle via godror, ...), instead of being
+// MySQL-only.
+//
+// Column types (including the BLOB/bytea used for SessionItem.Value) are
+// left to gorp's own Dialect.ToSqlType, which already maps Go kinds to the
+// right native type per database; Dialect only needs to add what gorp
+// doesn't standardize: upsert and bounded-delete syntax.
+type Dialect struct {
+	gorp.Dialect
+
+	// DriverName is the database/sql driver this dialect expects to be
+	// registered, e.g. "mysql", "postgres", "sqlite3". It is informational
+	// only: the store works with any already-open *sql.DB regardless of
+	// which driver opened it.
+	DriverName string
+
+	// Upsert returns a query, using this dialect's bind-var placeholders
+	// in the argument order (id, namespace, value, expired_at), that
+	// inserts a session row or updates value/expired_at in place when a
+	// row with the same id and namespace already exists. A nil Upsert means the dialect
+	// has no native upsert and the store falls back to its legacy
+	// select-then-insert/update path.
+	Upsert func(tableName string) string
+
+	// DeleteExpiredBatch returns a query, using this dialect's bind-var
+	// placeholders in the argument order (namespace, expired_at, limit),
+	// that deletes at most "limit" expired rows in the given namespace.
+	// It must never be unbounded: the GC loop relies on the affected row
+	// count being capped at limit to detect when it's caught up.
+	DeleteExpiredBatch func(tableName string) string
+}
+
+// quotedTable returns tableName quoted for direct interpolation into raw
+// SQL (gorp only quotes identifiers itself for queries it generates).
+func (d Dialect) quotedTable(tableName string) string {
+	return d.QuotedTableForQuery("", tableName)
+}
+
+// col quotes a single column name for direct interpolation into raw SQL.
+func (d Dialect) col(name string) string {
+	return d.QuoteField(name)
+}
+
+// bind returns the i'th (zero-based) bind-var placeholder for this
+// dialect, e.g. "?" for MySQL/SQLite, "$1"/"$2"/... for Postgres.
+func (d Dialect) bind(i int) string {
+	return d.BindVar(i)
+}
+
+// eqClause builds a "col=bindvar AND col=bindvar ..." fragment for cols,
+// numbering bind vars from offset. Use it to assemble WHERE clauses for raw
+// queries without hard-coding "?" in a way that breaks on dialects that
+// number their placeholders (Postgres' $1, $2, ...).
+func (d Dialect) eqClause(offset int, cols ...string) string {
+	return d.assignments(offset, " AND ", cols...)
+}
+
+// setClause builds a "col=bindvar, col=bindvar ..." fragment for cols,
+// numbering bind vars from offset. Use it for UPDATE ... SET lists, which
+// are comma-separated assignments, not the AND-joined predicates eqClause
+// produces for WHERE clauses.
+func (d Dialect) setClause(offset int, cols ...string) string {
+	return d.assignments(offset, ", ", cols...)
+}
+
+func (d Dialect) assignments(offset int, sep string, cols ...string) string {
+	parts := make([]string, len(cols))
+	for i, c := range cols {
+		parts[i] = fmt.Sprintf("%s=%s", d.col(c), d.bind(offset+i))
+	}
+	return strings.Join(parts, sep)
+}
+
+// MySQLDialect returns the Dialect used by NewStore/NewStoreWithDB. engine
+// and encoding default to "InnoDB" and "utf8mb4" when empty; InnoDB is
+// required for the transactional Refresh path to actually be atomic.
+func MySQLDialect(engine, encoding string) Dialect {
+	if engine == "" {
+		engine = "InnoDB"
+	}
+	if encoding == "" {
+		encoding = "utf8mb4"
+	}
+
+	d := Dialect{
+		Dialect:    gorp.MySQLDialect{Engine: engine, Encoding: encoding},
+		DriverName: "mysql",
+	}
+	d.Upsert = func(tableName string) string {
+		return fmt.Sprintf(
+			"INSERT INTO %s (%s,%s,%s,%s) VALUES (?,?,?,?) ON DUPLICATE KEY UPDATE %s=VALUES(%s), %s=VALUES(%s)",
+			d.quotedTable(tableName),
+			d.col("id"), d.col("namespace"), d.col("value"), d.col("expired_at"),
+			d.col("value"), d.col("value"), d.col("expired_at"), d.col("expired_at"),
+		)
+	}
+	d.DeleteExpiredBatch = func(tableName string) string {
+		return fmt.Sprintf(
+			"DELETE FROM %s WHERE %s=? AND %s<=? LIMIT ?",
+			d.quotedTable(tableName), d.col("namespace"), d.col("expired_at"),
+		)
+	}
+	return d
+}
+
+// PostgresDialect returns a Dialect backed by gorp's PostgresDialect, for
+// use with github.com/lib/pq or github.com/jackc/pgx's stdlib adapter.
+func PostgresDialect() Dialect {
+	d := Dialect{
+		Dialect:    gorp.PostgresDialect{},
+		DriverName: "postgres",
+	}
+	d.Upsert = func(tableName string) string {
+		return fmt.Sprintf(
+			"INSERT INTO %s (%s,%s,%s,%s) VALUES (%s,%s,%s,%s) ON CONFLICT (%s,%s) DO UPDATE SET %s=EXCLUDED.%s, %s=EXCLUDED.%s",
+			d.quotedTable(tableName),
+			d.col("id"), d.col("namespace"), d.col("value"), d.col("expired_at"),
+			d.bind(0), d.bind(1), d.bind(2), d.bind(3),
+			d.col("id"), d.col("namespace"),
+			d.col("value"), d.col("value"), d.col("expired_at"), d.col("expired_at"),
+		)
+	}
+	d.DeleteExpiredBatch = func(tableName string) string {
+		table := d.quotedTable(tableName)
+		return fmt.Sprintf(
+			"DELETE FROM %s WHERE ctid IN (SELECT ctid FROM %s WHERE %s=%s AND %s<=%s LIMIT %s)",
+			table, table, d.col("namespace"), d.bind(0), d.col("expired_at"), d.bind(1), d.bind(2),
+		)
+	}
+	return d
+}
+
+// SQLiteDialect returns a Dialect backed by gorp's SqliteDialect, for use
+// with github.com/mattn/go-sqlite3.
+func SQLiteDialect() Dialect {
+	d := Dialect{
+		Dialect:    gorp.SqliteDialect{},
+		DriverName: "sqlite3",
+	}
+	d.Upsert = func(tableName string) string {
+		return fmt.Sprintf(
+			"INSERT INTO %s (%s,%s,%s,%s) VALUES (?,?,?,?) ON CONFLICT(%s,%s) DO UPDATE SET %s=excluded.%s, %s=excluded.%s",
+			d.quotedTable(tableName),
+			d.col("id"), d.col("namespace"), d.col("value"), d.col("expired_at"),
+			d.col("id"), d.col("namespace"),
+			d.col("value"), d.col("value"), d.col("expired_at"), d.col("expired_at"),
+		)
+	}
+	d.DeleteExpiredBatch = func(tableName string) string {
+		table := d.quotedTable(tableName)
+		return fmt.Sprintf(
+			"DELETE FROM %s WHERE rowid IN (SELECT rowid FROM %s WHERE %s=? AND %s<=? LIMIT ?)",
+			table, table, d.col("namespace"), d.col("expired_at"),
+		)
+	}
+	return d
+}
+
+// Note: MSSQL and Oracle are not built in here because gorp doesn't ship
+// dialects for them out of the box. Construct a Dialect around your own
+// gorp.Dialect implementation (or a community one) for those, e.g. pairing
+// github.com/godror/godror with a gorp.Dialect that speaks Oracle's MERGE
+// instead of ON DUPLICATE KEY UPDATE, and pass it to NewStoreWithDialect.
@@ -0,0 +1,70 @@
+package mysql
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEqClauseAndSetClause(t *testing.T) {
+	d := MySQLDialect("", "")
+
+	Convey("eqClause AND-joins predicates for a WHERE clause", t, func() {
+		So(d.eqClause(0, "id", "namespace"), ShouldEqual, "`id`=? AND `namespace`=?")
+	})
+
+	Convey("setClause comma-joins assignments for an UPDATE ... SET list", t, func() {
+		So(d.setClause(0, "value", "expired_at"), ShouldEqual, "`value`=?, `expired_at`=?")
+	})
+}
+
+func TestMySQLDialectQueries(t *testing.T) {
+	d := MySQLDialect("", "")
+
+	Convey("MySQLDialect queries", t, func() {
+		Convey("Upsert uses ON DUPLICATE KEY UPDATE with a comma-joined SET list", func() {
+			q := d.Upsert("go_session")
+			So(q, ShouldContainSubstring, "ON DUPLICATE KEY UPDATE")
+			So(q, ShouldContainSubstring, "`value`=VALUES(`value`), `expired_at`=VALUES(`expired_at`)")
+			// Regression guard: the SET list must never contain "AND", which
+			// would mean eqClause leaked into an UPDATE/upsert SET clause.
+			setList := q[strings.Index(q, "UPDATE ")+len("UPDATE "):]
+			So(setList, ShouldNotContainSubstring, " AND ")
+		})
+
+		Convey("DeleteExpiredBatch filters by namespace and bounds the delete with LIMIT", func() {
+			q := d.DeleteExpiredBatch("go_session")
+			So(q, ShouldContainSubstring, "`namespace`=?")
+			So(q, ShouldContainSubstring, "`expired_at`<=?")
+			So(q, ShouldContainSubstring, "LIMIT ?")
+		})
+	})
+}
+
+func TestPostgresDialectQueries(t *testing.T) {
+	d := PostgresDialect()
+
+	Convey("Postgres queries use $-numbered bind vars and a comma-joined upsert SET list", t, func() {
+		q := d.Upsert("go_session")
+		So(q, ShouldContainSubstring, "$1")
+		So(q, ShouldContainSubstring, "ON CONFLICT (\"id\",\"namespace\")")
+		So(q, ShouldContainSubstring, "\"value\"=EXCLUDED.\"value\", \"expired_at\"=EXCLUDED.\"expired_at\"")
+
+		Convey("DeleteExpiredBatch scopes the subquery to the namespace before limiting rows", func() {
+			q := d.DeleteExpiredBatch("go_session")
+			So(q, ShouldContainSubstring, "\"namespace\"=$1")
+			So(q, ShouldContainSubstring, "\"expired_at\"<=$2")
+			So(q, ShouldContainSubstring, "LIMIT $3")
+		})
+	})
+}
+
+func TestSQLiteDialectQueries(t *testing.T) {
+	d := SQLiteDialect()
+
+	Convey("SQLite upsert conflict target covers id and namespace", t, func() {
+		q := d.Upsert("go_session")
+		So(q, ShouldContainSubstring, "ON CONFLICT(\"id\",\"namespace\")")
+	})
+}
@@ -0,0 +1,65 @@
+package mysql
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// gcMetrics holds the Prometheus collectors registered for a store's GC
+// loop when Config.Metrics is set. All fields are nil (and every method a
+// no-op) when metrics are disabled.
+type gcMetrics struct {
+	deletedTotal  prometheus.Counter
+	duration      prometheus.Histogram
+	activeCounter prometheus.GaugeFunc
+}
+
+// newGCMetrics registers the store's counters/histogram/gauge with reg and
+// returns the handles used to update them. reg may be nil, in which case
+// metrics collection is disabled entirely. Collectors are labelled by both
+// table and namespace, since NewStoreWithNamespace lets several
+// managerStores share one table name, and those would otherwise register
+// identical collectors and panic.
+func newGCMetrics(reg prometheus.Registerer, tableName, namespace string, active func() float64) *gcMetrics {
+	if reg == nil {
+		return nil
+	}
+
+	labels := prometheus.Labels{"table": tableName, "namespace": namespace}
+	m := &gcMetrics{
+		deletedTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "sessions_gc_deleted_total",
+			Help:        "Total number of expired session rows removed by the GC loop.",
+			ConstLabels: labels,
+		}),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:        "sessions_gc_duration_seconds",
+			Help:        "Time spent per GC run, across all of its batched DELETEs.",
+			ConstLabels: labels,
+		}),
+	}
+	if active != nil {
+		m.activeCounter = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "sessions_active",
+			Help:        "Estimated number of non-expired session rows.",
+			ConstLabels: labels,
+		}, active)
+	}
+
+	reg.MustRegister(m.deletedTotal, m.duration)
+	if m.activeCounter != nil {
+		reg.MustRegister(m.activeCounter)
+	}
+	return m
+}
+
+func (m *gcMetrics) addDeleted(n int64) {
+	if m == nil {
+		return
+	}
+	m.deletedTotal.Add(float64(n))
+}
+
+func (m *gcMetrics) observeDuration(seconds float64) {
+	if m == nil {
+		return
+	}
+	m.duration.Observe(seconds)
+}
@@ -5,20 +5,25 @@ import (
 	"database/sql"
 	"fmt"
 	"io"
+	"math/rand"
 	"os"
 	"sync"
 	"time"
 
 	"github.com/go-session/session"
-	"github.com/json-iterator/go"
+	"github.com/prometheus/client_golang/prometheus"
 	"gopkg.in/gorp.v2"
 )
 
 var (
-	_             session.ManagerStore = &managerStore{}
-	_             session.Store        = &store{}
-	jsonMarshal                        = jsoniter.Marshal
-	jsonUnmarshal                      = jsoniter.Unmarshal
+	_ session.ManagerStore = &managerStore{}
+	_ session.Store        = &store{}
+)
+
+const (
+	defaultTableName   = "go_session"
+	defaultGCInterval  = 600
+	defaultGCBatchSize = 1000
 )
 
 // NewConfig create mysql configuration instance
@@ -28,6 +33,9 @@ func NewConfig(dsn string) *Config {
 		ConnMaxLifetime: time.Hour * 2,
 		MaxOpenConns:    50,
 		MaxIdleConns:    25,
+		Engine:          "InnoDB",
+		Encoding:        "utf8mb4",
+		GCBatchSize:     defaultGCBatchSize,
 	}
 }
 
@@ -37,6 +45,100 @@ type Config struct {
 	ConnMaxLifetime time.Duration
 	MaxOpenConns    int
 	MaxIdleConns    int
+
+	// Engine is the MySQL storage engine used for the session table when
+	// NewStore/NewStoreWithDB picks the dialect. Defaults to InnoDB, which
+	// (unlike MyISAM) supports the transactions Refresh relies on for
+	// atomic session rotation. Ignored by NewStoreWithDialect, whose
+	// caller supplies the dialect directly.
+	Engine string
+	// Encoding is the MySQL character set used for the session table.
+	// Defaults to utf8mb4. Ignored by NewStoreWithDialect.
+	Encoding string
+
+	// Namespace isolates the rows owned by this store within a table that
+	// may be shared by other applications/tenants. All queries (Check,
+	// Create, Update, Delete, Refresh, Save, and the GC loop) filter by
+	// it, so rows from different namespaces never collide even when they
+	// share a sid. Empty means no isolation (the historical behaviour).
+	Namespace string
+
+	// Codec controls how session values are serialized before being
+	// written to the value column. Defaults to JSONCodec.
+	Codec Codec
+
+	// GCBatchSize caps how many expired rows a single DELETE issued by
+	// the GC loop may remove, so GC never holds a table lock long enough
+	// to stall other queries or blow up the binlog. Defaults to 1000.
+	GCBatchSize int
+	// GCMaxDuration bounds how long one GC run may keep issuing batched
+	// deletes before yielding until the next tick, regardless of how many
+	// expired rows remain. Zero (the default) means unbounded.
+	GCMaxDuration time.Duration
+
+	// Metrics, when set, receives the store's GC/session-count counters
+	// (sessions_gc_deleted_total, sessions_gc_duration_seconds,
+	// sessions_active). Nil disables metrics collection entirely.
+	Metrics prometheus.Registerer
+}
+
+// The accessors below make every Config field optional: a nil *Config
+// behaves exactly like a zero-value one, falling back to the package
+// defaults everywhere a Config-less caller previously relied on them.
+
+func (c *Config) codec() Codec {
+	if c != nil && c.Codec != nil {
+		return c.Codec
+	}
+	return JSONCodec
+}
+
+func (c *Config) gcBatchSize() int {
+	if c != nil && c.GCBatchSize > 0 {
+		return c.GCBatchSize
+	}
+	return defaultGCBatchSize
+}
+
+func (c *Config) gcMaxDuration() time.Duration {
+	if c == nil {
+		return 0
+	}
+	return c.GCMaxDuration
+}
+
+func (c *Config) namespace() string {
+	if c == nil {
+		return ""
+	}
+	return c.Namespace
+}
+
+func (c *Config) engine() string {
+	if c == nil || c.Engine == "" {
+		return "InnoDB"
+	}
+	return c.Engine
+}
+
+func (c *Config) encoding() string {
+	if c == nil || c.Encoding == "" {
+		return "utf8mb4"
+	}
+	return c.Encoding
+}
+
+func (c *Config) metrics() prometheus.Registerer {
+	if c == nil {
+		return nil
+	}
+	return c.Metrics
+}
+
+// NewDefaultStore creates a mysql store using the default table name
+// (go_session) and GC interval (600s).
+func NewDefaultStore(config *Config) session.ManagerStore {
+	return NewStore(config, "", 0)
 }
 
 // NewStore Create an instance of a mysql store,
@@ -52,53 +154,109 @@ func NewStore(config *Config, tableName string, gcInterval int) session.ManagerS
 	db.SetMaxIdleConns(config.MaxIdleConns)
 	db.SetConnMaxLifetime(config.ConnMaxLifetime)
 
-	return NewStoreWithDB(db, tableName, gcInterval)
+	return NewStoreWithDB(config, db, tableName, gcInterval)
 }
 
-// NewStoreWithDB Create an instance of a mysql store,
-// tableName Specify the stored table name (default go_session),
+// NewStoreWithDB Create an instance of a mysql store around an already-open
+// *sql.DB, tableName Specify the stored table name (default go_session),
 // gcInterval Time interval for executing GC (in seconds, default 600)
-func NewStoreWithDB(db *sql.DB, tableName string, gcInterval int) session.ManagerStore {
-	store := &managerStore{
-		db:        &gorp.DbMap{Db: db, Dialect: gorp.MySQLDialect{Encoding: "UTF8", Engine: "MyISAM"}},
-		tableName: "go_session",
+func NewStoreWithDB(config *Config, db *sql.DB, tableName string, gcInterval int) session.ManagerStore {
+	return NewStoreWithDialect(config, db, MySQLDialect(config.engine(), config.encoding()), tableName, gcInterval)
+}
+
+// NewStoreWithNamespace is a convenience wrapper around NewStoreWithDialect
+// that isolates the store's rows to namespace, so a single shared
+// table/database can back many logical applications or tenants without key
+// collisions. It does not mutate config; config may be nil.
+func NewStoreWithNamespace(config *Config, db *sql.DB, dialect Dialect, tableName, namespace string, gcInterval int) session.ManagerStore {
+	var cfg Config
+	if config != nil {
+		cfg = *config
+	}
+	cfg.Namespace = namespace
+	return NewStoreWithDialect(&cfg, db, dialect, tableName, gcInterval)
+}
+
+// NewStoreWithDialect creates a ManagerStore backed by an arbitrary SQL
+// dialect, so the same store can run on Postgres, SQLite, MySQL, or any
+// other backend a caller supplies a gorp.Dialect for (MSSQL, Oracle via
+// godror, ...) instead of being MySQL-only. db must already be open and
+// connected to a database that speaks dialect's SQL; dialect.DriverName is
+// not checked.
+//
+// CreateTablesIfNotExists only creates the table when it doesn't already
+// exist, so a table created by a pre-namespace version of this package
+// (without a namespace column, and with id as a sole primary key) is left
+// untouched on upgrade and every namespace-filtered query below will fail
+// with an "unknown column" error. Before upgrading an existing deployment,
+// migrate the table by hand, e.g. for MySQL:
+//
+//	ALTER TABLE go_session ADD COLUMN namespace VARCHAR(255) NOT NULL DEFAULT '';
+//	ALTER TABLE go_session DROP PRIMARY KEY, ADD PRIMARY KEY (id, namespace);
+//	CREATE INDEX idx_namespace_id ON go_session (namespace, id);
+//	CREATE INDEX idx_namespace_expired_at ON go_session (namespace, expired_at);
+func NewStoreWithDialect(config *Config, db *sql.DB, dialect Dialect, tableName string, gcInterval int) session.ManagerStore {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &managerStore{
+		db:        &gorp.DbMap{Db: db, Dialect: dialect.Dialect},
+		dialect:   dialect,
+		tableName: defaultTableName,
+		namespace: config.namespace(),
+		codec:     config.codec(),
 		stdout:    os.Stderr,
+		gcBatch:   config.gcBatchSize(),
+		gcMax:     config.gcMaxDuration(),
+		cancel:    cancel,
 	}
 
 	if tableName != "" {
-		store.tableName = tableName
+		s.tableName = tableName
 	}
 
-	interval := 600
+	interval := defaultGCInterval
 	if gcInterval > 0 {
 		interval = gcInterval
 	}
-	store.ticker = time.NewTicker(time.Second * time.Duration(interval))
+	s.gcInterval = time.Second * time.Duration(interval)
 
-	store.pool = sync.Pool{
+	s.pool = sync.Pool{
 		New: func() interface{} {
-			return newStore(store.db, store.tableName)
+			return newStore(s.db, s.dialect, s.tableName, s.namespace, s.codec)
 		},
 	}
 
-	store.db.AddTableWithName(SessionItem{}, store.tableName)
+	s.db.AddTableWithName(SessionItem{}, s.tableName)
 
-	err := store.db.CreateTablesIfNotExists()
-	if err != nil {
+	if err := s.db.CreateTablesIfNotExists(); err != nil {
 		panic(err)
 	}
-	store.db.Exec(fmt.Sprintf("CREATE INDEX `idx_expired_at` ON %s (`expired_at`);", store.tableName))
+	s.db.Exec(fmt.Sprintf("CREATE INDEX %s ON %s (%s);",
+		dialect.col("idx_expired_at"), dialect.quotedTable(s.tableName), dialect.col("expired_at")))
+	s.db.Exec(fmt.Sprintf("CREATE INDEX %s ON %s (%s,%s);",
+		dialect.col("idx_namespace_id"), dialect.quotedTable(s.tableName), dialect.col("namespace"), dialect.col("id")))
+	s.db.Exec(fmt.Sprintf("CREATE INDEX %s ON %s (%s,%s);",
+		dialect.col("idx_namespace_expired_at"), dialect.quotedTable(s.tableName), dialect.col("namespace"), dialect.col("expired_at")))
+
+	s.metrics = newGCMetrics(config.metrics(), s.tableName, s.namespace, s.countActive)
 
-	go store.gc()
-	return store
+	go s.gcLoop(ctx)
+	return s
 }
 
 type managerStore struct {
-	ticker    *time.Ticker
-	pool      sync.Pool
-	db        *gorp.DbMap
-	tableName string
-	stdout    io.Writer
+	pool       sync.Pool
+	db         *gorp.DbMap
+	dialect    Dialect
+	tableName  string
+	namespace  string
+	codec      Codec
+	stdout     io.Writer
+	gcInterval time.Duration
+	gcBatch    int
+	gcMax      time.Duration
+	metrics    *gcMetrics
+	cancel     context.CancelFunc
 }
 
 func (s *managerStore) errorf(format string, args ...interface{}) {
@@ -108,57 +266,114 @@ func (s *managerStore) errorf(format string, args ...interface{}) {
 	}
 }
 
-func (s *managerStore) gc() {
-	for range s.ticker.C {
-		now := time.Now().Unix()
-		query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE expired_at<=?", s.tableName)
-		n, err := s.db.SelectInt(query, now)
+// gcLoop runs runGC on a jittered interval until ctx is cancelled by Close.
+// Jitter avoids every replica in a fleet hammering the table at the exact
+// same instant when they all start their tickers together.
+func (s *managerStore) gcLoop(ctx context.Context) {
+	timer := time.NewTimer(jitter(s.gcInterval))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			s.runGC(ctx)
+			timer.Reset(jitter(s.gcInterval))
+		}
+	}
+}
+
+func jitter(d time.Duration) time.Duration {
+	spread := d / 10
+	if spread <= 0 {
+		return d
+	}
+	return d - spread + time.Duration(rand.Int63n(int64(2*spread+1)))
+}
+
+// runGC deletes expired rows in batches of s.gcBatch so a single run never
+// locks the table (or grows the binlog) the way one unbounded DELETE would.
+// It keeps issuing batches until a batch affects fewer rows than gcBatch
+// (meaning it caught up) or, if s.gcMax is set, until that much time has
+// passed, whichever comes first.
+func (s *managerStore) runGC(ctx context.Context) {
+	start := time.Now()
+	var deadline time.Time
+	if s.gcMax > 0 {
+		deadline = start.Add(s.gcMax)
+	}
+
+	query := s.dialect.DeleteExpiredBatch(s.tableName)
+	now := time.Now().Unix()
+
+	for {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			break
+		}
+
+		res, err := s.db.WithContext(ctx).Exec(query, s.namespace, now, s.gcBatch)
 		if err != nil {
 			s.errorf("[ERROR]:%s", err.Error())
-			return
-		} else if n > 0 {
-			_, err = s.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE expired_at<=?", s.tableName), now)
-			if err != nil {
-				s.errorf("[ERROR]:%s", err.Error())
-			}
+			break
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			s.errorf("[ERROR]:%s", err.Error())
+			break
+		}
+		s.metrics.addDeleted(n)
+		if n < int64(s.gcBatch) {
+			break
 		}
 	}
+
+	s.metrics.observeDuration(time.Since(start).Seconds())
 }
 
-func (s *managerStore) getValue(sid string) (string, error) {
+func (s *managerStore) countActive() float64 {
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s AND %s>%s",
+		s.dialect.quotedTable(s.tableName), s.dialect.eqClause(0, "namespace"),
+		s.dialect.col("expired_at"), s.dialect.bind(1))
+	n, err := s.db.SelectInt(query, s.namespace, time.Now().Unix())
+	if err != nil {
+		return 0
+	}
+	return float64(n)
+}
+
+func (s *managerStore) getValue(ctx context.Context, sid string) ([]byte, error) {
 	var item SessionItem
 
-	err := s.db.SelectOne(&item, fmt.Sprintf("SELECT * FROM %s WHERE id=?", s.tableName), sid)
+	query := fmt.Sprintf("SELECT * FROM %s WHERE %s",
+		s.dialect.quotedTable(s.tableName), s.dialect.eqClause(0, "id", "namespace"))
+	err := s.db.WithContext(ctx).SelectOne(&item, query, sid, s.namespace)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return "", nil
+			return nil, nil
 		}
-		return "", nil
+		return nil, err
 	} else if time.Now().Unix() >= item.ExpiredAt {
-		return "", nil
+		return nil, nil
 	}
 
 	return item.Value, nil
 }
 
-func (s *managerStore) parseValue(value string) (map[string]interface{}, error) {
+func (s *managerStore) parseValue(value []byte) (map[string]interface{}, error) {
 	var values map[string]interface{}
-	if len(value) > 0 {
-		err := jsonUnmarshal([]byte(value), &values)
-		if err != nil {
-			return nil, err
-		}
+	if err := s.codec.Unmarshal(value, &values); err != nil {
+		return nil, err
 	}
-
 	return values, nil
 }
 
-func (s *managerStore) Check(_ context.Context, sid string) (bool, error) {
-	val, err := s.getValue(sid)
+func (s *managerStore) Check(ctx context.Context, sid string) (bool, error) {
+	val, err := s.getValue(ctx, sid)
 	if err != nil {
 		return false, err
 	}
-	return val != "", nil
+	return len(val) > 0, nil
 }
 
 func (s *managerStore) Create(ctx context.Context, sid string, expired int64) (session.Store, error) {
@@ -170,17 +385,21 @@ func (s *managerStore) Create(ctx context.Context, sid string, expired int64) (s
 func (s *managerStore) Update(ctx context.Context, sid string, expired int64) (session.Store, error) {
 	store := s.pool.Get().(*store)
 
-	value, err := s.getValue(sid)
+	value, err := s.getValue(ctx, sid)
 	if err != nil {
 		return nil, err
-	} else if value == "" {
+	} else if len(value) == 0 {
 		store.reset(ctx, sid, expired, nil)
 		return store, nil
 	}
 
-	_, err = s.db.Exec(fmt.Sprintf("UPDATE %s SET expired_at=? WHERE id=?", s.tableName),
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s",
+		s.dialect.quotedTable(s.tableName),
+		s.dialect.setClause(0, "expired_at"),
+		s.dialect.eqClause(1, "id", "namespace"))
+	_, err = s.db.WithContext(ctx).Exec(query,
 		time.Now().Add(time.Duration(expired)*time.Second).Unix(),
-		sid)
+		sid, s.namespace)
 	if err != nil {
 		return nil, err
 	}
@@ -194,33 +413,51 @@ func (s *managerStore) Update(ctx context.Context, sid string, expired int64) (s
 	return store, nil
 }
 
-func (s *managerStore) Delete(_ context.Context, sid string) error {
-	_, err := s.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE id=?", s.tableName), sid)
+func (s *managerStore) Delete(ctx context.Context, sid string) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s",
+		s.dialect.quotedTable(s.tableName), s.dialect.eqClause(0, "id", "namespace"))
+	_, err := s.db.WithContext(ctx).Exec(query, sid, s.namespace)
 	return err
 }
 
 func (s *managerStore) Refresh(ctx context.Context, oldsid, sid string, expired int64) (session.Store, error) {
 	store := s.pool.Get().(*store)
 
-	value, err := s.getValue(oldsid)
+	value, err := s.getValue(ctx, oldsid)
 	if err != nil {
 		return nil, err
-	} else if value == "" {
+	} else if len(value) == 0 {
 		store.reset(ctx, sid, expired, nil)
 		return store, nil
 	}
 
-	err = s.db.Insert(&SessionItem{
+	// Insert the new row and delete the old one inside one transaction,
+	// so a crash between the two never leaves duplicate session data.
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	txExec := tx.WithContext(ctx)
+
+	err = txExec.Insert(&SessionItem{
 		ID:        sid,
+		Namespace: s.namespace,
 		Value:     value,
 		ExpiredAt: time.Now().Add(time.Duration(expired) * time.Second).Unix(),
 	})
 	if err != nil {
+		tx.Rollback()
 		return nil, err
 	}
 
-	err = s.Delete(nil, oldsid)
-	if err != nil {
+	deleteQuery := fmt.Sprintf("DELETE FROM %s WHERE %s",
+		s.dialect.quotedTable(s.tableName), s.dialect.eqClause(0, "id", "namespace"))
+	if _, err = txExec.Exec(deleteQuery, oldsid, s.namespace); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
 		return nil, err
 	}
 
@@ -234,15 +471,18 @@ func (s *managerStore) Refresh(ctx context.Context, oldsid, sid string, expired
 }
 
 func (s *managerStore) Close() error {
-	s.ticker.Stop()
+	s.cancel()
 	s.db.Db.Close()
 	return nil
 }
 
-func newStore(db *gorp.DbMap, tableName string) *store {
+func newStore(db *gorp.DbMap, dialect Dialect, tableName, namespace string, codec Codec) *store {
 	return &store{
 		db:        db,
+		dialect:   dialect,
 		tableName: tableName,
+		namespace: namespace,
+		codec:     codec,
 	}
 }
 
@@ -250,7 +490,10 @@ type store struct {
 	sync.RWMutex
 	ctx       context.Context
 	db        *gorp.DbMap
+	dialect   Dialect
 	tableName string
+	namespace string
+	codec     Codec
 	sid       string
 	expired   int64
 	values    map[string]interface{}
@@ -307,41 +550,54 @@ func (s *store) Flush() error {
 }
 
 func (s *store) Save() error {
-	var value string
-
 	s.RLock()
-	if len(s.values) > 0 {
-		buf, err := jsonMarshal(s.values)
-		if err != nil {
-			s.RUnlock()
-			return err
-		}
-		value = string(buf)
-	}
+	value, err := s.codec.Marshal(s.values)
 	s.RUnlock()
+	if err != nil {
+		return err
+	}
 
-	n, err := s.db.SelectInt(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE id=?", s.tableName), s.sid)
+	expiredAt := time.Now().Add(time.Duration(s.expired) * time.Second).Unix()
+	exec := s.db.WithContext(s.ctx)
+
+	if upsert := s.dialect.Upsert; upsert != nil {
+		_, err := exec.Exec(upsert(s.tableName), s.sid, s.namespace, value, expiredAt)
+		return err
+	}
+
+	// Dialect has no native upsert: fall back to the legacy
+	// select-then-insert/update path. This still races under concurrent
+	// Saves for the same sid on such dialects.
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s",
+		s.dialect.quotedTable(s.tableName), s.dialect.eqClause(0, "id", "namespace"))
+	n, err := exec.SelectInt(query, s.sid, s.namespace)
 	if err != nil {
 		return err
 	} else if n == 0 {
-		return s.db.Insert(&SessionItem{
+		return exec.Insert(&SessionItem{
 			ID:        s.sid,
+			Namespace: s.namespace,
 			Value:     value,
-			ExpiredAt: time.Now().Add(time.Duration(s.expired) * time.Second).Unix(),
+			ExpiredAt: expiredAt,
 		})
 	}
 
-	_, err = s.db.Exec(fmt.Sprintf("UPDATE %s SET value=?,expired_at=? WHERE id=?", s.tableName),
-		value,
-		time.Now().Add(time.Duration(s.expired)*time.Second).Unix(),
-		s.sid)
-
+	updateQuery := fmt.Sprintf("UPDATE %s SET %s WHERE %s",
+		s.dialect.quotedTable(s.tableName),
+		s.dialect.setClause(0, "value", "expired_at"),
+		s.dialect.eqClause(2, "id", "namespace"))
+	_, err = exec.Exec(updateQuery, value, expiredAt, s.sid, s.namespace)
 	return err
 }
 
-// SessionItem Data items stored in mysql
+// SessionItem Data items stored in the session table. ID and Namespace
+// together form the primary key: a namespace only isolates sessions from
+// other namespaces if two different namespaces are allowed to reuse the
+// same sid, so the key (and every dialect's Upsert conflict target) must
+// cover both columns, not ID alone.
 type SessionItem struct {
 	ID        string `db:"id,primarykey,size:255"`
-	Value     string `db:"value,size:2048"`
+	Namespace string `db:"namespace,primarykey,size:255"`
+	Value     []byte `db:"value"`
 	ExpiredAt int64  `db:"expired_at"`
 }